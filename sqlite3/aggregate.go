@@ -0,0 +1,422 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+extern void _go_aggregate_step(sqlite3_context*, int, sqlite3_value**);
+extern void _go_aggregate_final(sqlite3_context*);
+extern void _go_aggregate_value(sqlite3_context*);
+extern void _go_aggregate_inverse(sqlite3_context*, int, sqlite3_value**);
+extern void _go_aggregate_destroy(void*);
+
+static int _sqlite3_create_aggregate(sqlite3* db, const char* name, int nArg, int eTextRep, void* handle) {
+  return sqlite3_create_function_v2(db, name, nArg, eTextRep, handle, 0,
+    _go_aggregate_step, _go_aggregate_final, _go_aggregate_destroy);
+}
+
+static int _sqlite3_create_window_function(sqlite3* db, const char* name, int nArg, int eTextRep, void* handle) {
+  return sqlite3_create_window_function(db, name, nArg, eTextRep, handle,
+    _go_aggregate_step, _go_aggregate_final, _go_aggregate_value, _go_aggregate_inverse, _go_aggregate_destroy);
+}
+*/
+import "C"
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// Aggregator is implemented by user-defined aggregate functions registered
+// with Conn.CreateAggregate. When registered through a func() Aggregator
+// constructor, a new Aggregator is obtained for every aggregation context
+// (i.e. every GROUP BY bucket), so implementations may keep state as
+// ordinary struct fields; an Aggregator value registered directly is shared
+// by every context.
+type Aggregator interface {
+	// Step is called once per row with the function's arguments.
+	Step(args ...interface{}) error
+	// Done is called once all rows have been stepped through, and returns
+	// the final value of the aggregate.
+	Done() (interface{}, error)
+}
+
+// WindowAggregator extends Aggregator with the methods required to run a
+// Go aggregate inside an OVER (...) window clause, where rows can leave the
+// window as well as enter it.
+type WindowAggregator interface {
+	Aggregator
+
+	// Inverse removes a row that is leaving the window, undoing a previous
+	// Step call with the same arguments.
+	Inverse(args ...interface{}) error
+	// Value returns the current value of the aggregate without finalizing
+	// it; unlike Done it may be called many times.
+	Value() (interface{}, error)
+}
+
+// aggFunc describes a registered aggregate: how to build a fresh Aggregator
+// for each aggregation context, and how to marshal arguments/results. window
+// is read by the xValue/xInverse trampolines to reject running the
+// window-only half of WindowAggregator against a plain Aggregator instead of
+// trusting the type assertion to always succeed.
+type aggFunc struct {
+	newAgg func() (Aggregator, error)
+	ret    callbackRetConverter
+	window bool
+}
+
+// aggRegistry maps the opaque handle passed as sqlite3_user_data to the
+// aggFunc that was registered for it. SQLite invokes our C trampolines with
+// only that handle and the per-context aggregate memory, so this indirection
+// is how we get back to the Go world without smuggling Go pointers through C.
+// Every access, including from the C trampolines below, goes through the
+// lock: a CreateAggregate on one connection can run concurrently with a step
+// callback on another.
+var aggRegistry = struct {
+	sync.Mutex
+	m    map[uintptr]*aggFunc
+	next uintptr
+}{m: map[uintptr]*aggFunc{}}
+
+func registerAggFunc(f *aggFunc) uintptr {
+	aggRegistry.Lock()
+	defer aggRegistry.Unlock()
+	aggRegistry.next++
+	id := aggRegistry.next
+	aggRegistry.m[id] = f
+	return id
+}
+
+func lookupAggFunc(id uintptr) *aggFunc {
+	aggRegistry.Lock()
+	defer aggRegistry.Unlock()
+	return aggRegistry.m[id]
+}
+
+// aggInstance holds the live Aggregator for one in-progress aggregation
+// context, plus any Step/Inverse error so it can be reported from xFinal.
+// funcID is the aggRegistry handle it was created for, so
+// _go_aggregate_destroy can sweep orphaned instances when the function
+// itself is torn down.
+type aggInstance struct {
+	agg    Aggregator
+	err    error
+	funcID uintptr
+}
+
+// aggInstances maps a per-context handle, stored in the memory returned by
+// sqlite3_aggregate_context, to the Go Aggregator for that context. This
+// keeps the Aggregator reachable for the garbage collector across Step
+// calls and lets xFinal clean it up deterministically.
+var aggInstances = struct {
+	sync.Mutex
+	m    map[uintptr]*aggInstance
+	next uintptr
+}{m: map[uintptr]*aggInstance{}}
+
+func newAggInstance(inst *aggInstance) uintptr {
+	aggInstances.Lock()
+	defer aggInstances.Unlock()
+	aggInstances.next++
+	id := aggInstances.next
+	aggInstances.m[id] = inst
+	return id
+}
+
+func lookupAggInstance(id uintptr) *aggInstance {
+	aggInstances.Lock()
+	defer aggInstances.Unlock()
+	return aggInstances.m[id]
+}
+
+func freeAggInstance(id uintptr) {
+	aggInstances.Lock()
+	defer aggInstances.Unlock()
+	delete(aggInstances.m, id)
+}
+
+// freeAggInstancesForFunc drops every still-live instance created for
+// funcID. It is called from _go_aggregate_destroy, which SQLite invokes
+// when a registration is dropped (connection close, or the name being
+// re-registered) - the main path by which an aggregate can be abandoned
+// without xFinal ever running, e.g. because the query that drove it was
+// interrupted. A single still-open connection interrupting one query among
+// many it continues to run can still leak its instance until the
+// connection itself closes; SQLite's C API has no per-context destructor to
+// hook for that narrower case.
+func freeAggInstancesForFunc(funcID uintptr) {
+	aggInstances.Lock()
+	defer aggInstances.Unlock()
+	for id, inst := range aggInstances.m {
+		if inst.funcID == funcID {
+			delete(aggInstances.m, id)
+		}
+	}
+}
+
+// aggContextID returns the per-context handle for ctx, allocating one (and
+// the backing Aggregator) on the first call for that context.
+func aggContextID(ctx *C.sqlite3_context, funcID uintptr, f *aggFunc) (uintptr, error) {
+	pctx := (*uintptr)(C.sqlite3_aggregate_context(ctx, C.int(unsafe.Sizeof(uintptr(0)))))
+	if pctx == nil {
+		return 0, pkgErr(NOMEM, "could not allocate aggregate context")
+	}
+	if *pctx != 0 {
+		return *pctx, nil
+	}
+	agg, err := f.newAgg()
+	if err != nil {
+		return 0, err
+	}
+	id := newAggInstance(&aggInstance{agg: agg, funcID: funcID})
+	*pctx = id
+	return id, nil
+}
+
+// aggArgs converts a row's SQL arguments to the interface{} values passed to
+// Aggregator.Step/Inverse. Aggregator's signature takes ...interface{}
+// rather than fixed, typed parameters, so conversion happens per value at
+// call time via callbackArgGeneric rather than from a fixed set of
+// registration-time converters.
+func aggArgs(n C.int, argv **C.sqlite3_value) ([]interface{}, error) {
+	args := make([]interface{}, int(n))
+	values := unsafe.Slice(argv, int(n))
+	for i, v := range values {
+		val, err := callbackArgGeneric(v)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val.Interface()
+	}
+	return args, nil
+}
+
+// windowAgg returns inst's Aggregator as a WindowAggregator, reporting a
+// MISUSE error through ctx instead of panicking if f was somehow invoked as
+// a window function without being registered as one.
+func windowAgg(ctx *C.sqlite3_context, f *aggFunc, inst *aggInstance) (WindowAggregator, bool) {
+	if !f.window {
+		callbackError(ctx, pkgErr(MISUSE, "aggregate was not registered as a window function"))
+		return nil, false
+	}
+	wagg, ok := inst.agg.(WindowAggregator)
+	if !ok {
+		callbackError(ctx, pkgErr(MISUSE, "aggregate does not implement WindowAggregator"))
+		return nil, false
+	}
+	return wagg, true
+}
+
+//export _go_aggregate_step
+func _go_aggregate_step(ctx *C.sqlite3_context, n C.int, argv **C.sqlite3_value) {
+	id := handleID(C.sqlite3_user_data(ctx))
+	f := lookupAggFunc(id)
+
+	cid, err := aggContextID(ctx, id, f)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	inst := lookupAggInstance(cid)
+	if inst.err != nil {
+		return
+	}
+
+	args, err := aggArgs(n, argv)
+	if err != nil {
+		inst.err = err
+		return
+	}
+	if err := inst.agg.Step(args...); err != nil {
+		inst.err = err
+	}
+}
+
+//export _go_aggregate_final
+func _go_aggregate_final(ctx *C.sqlite3_context) {
+	id := handleID(C.sqlite3_user_data(ctx))
+	f := lookupAggFunc(id)
+
+	cid, err := aggContextID(ctx, id, f)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	inst := lookupAggInstance(cid)
+	defer freeAggInstance(cid)
+
+	if inst.err != nil {
+		callbackError(ctx, inst.err)
+		return
+	}
+
+	result, err := inst.agg.Done()
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+
+	if err := f.ret(ctx, reflect.ValueOf(result)); err != nil {
+		callbackError(ctx, err)
+	}
+}
+
+//export _go_aggregate_value
+func _go_aggregate_value(ctx *C.sqlite3_context) {
+	id := handleID(C.sqlite3_user_data(ctx))
+	f := lookupAggFunc(id)
+
+	cid, err := aggContextID(ctx, id, f)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	inst := lookupAggInstance(cid)
+	if inst.err != nil {
+		callbackError(ctx, inst.err)
+		return
+	}
+
+	wagg, ok := windowAgg(ctx, f, inst)
+	if !ok {
+		return
+	}
+	result, err := wagg.Value()
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	if err := f.ret(ctx, reflect.ValueOf(result)); err != nil {
+		callbackError(ctx, err)
+	}
+}
+
+//export _go_aggregate_inverse
+func _go_aggregate_inverse(ctx *C.sqlite3_context, n C.int, argv **C.sqlite3_value) {
+	id := handleID(C.sqlite3_user_data(ctx))
+	f := lookupAggFunc(id)
+
+	cid, err := aggContextID(ctx, id, f)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	inst := lookupAggInstance(cid)
+	if inst.err != nil {
+		return
+	}
+
+	wagg, ok := windowAgg(ctx, f, inst)
+	if !ok {
+		return
+	}
+	args, err := aggArgs(n, argv)
+	if err != nil {
+		inst.err = err
+		return
+	}
+	if err := wagg.Inverse(args...); err != nil {
+		inst.err = err
+	}
+}
+
+//export _go_aggregate_destroy
+func _go_aggregate_destroy(handle unsafe.Pointer) {
+	id := handleID(handle)
+	aggRegistry.Lock()
+	delete(aggRegistry.m, id)
+	aggRegistry.Unlock()
+	freeAggInstancesForFunc(id)
+	handleFree(handle)
+}
+
+// newAggFunc builds an aggFunc from impl, which must either implement
+// Aggregator directly (in which case every aggregation context shares the
+// same instance - only safe for impl that carries no per-group state) or be
+// a func() Aggregator / func() WindowAggregator constructor invoked once per
+// aggregation context.
+func newAggFunc(impl interface{}, window bool) (*aggFunc, int, error) {
+	var newAgg func() (Aggregator, error)
+	var sample Aggregator
+
+	switch v := impl.(type) {
+	case func() Aggregator:
+		newAgg = func() (Aggregator, error) { return v(), nil }
+		sample = v()
+	case func() WindowAggregator:
+		newAgg = func() (Aggregator, error) { return v(), nil }
+		sample = v()
+	case Aggregator:
+		newAgg = func() (Aggregator, error) { return v, nil }
+		sample = v
+	default:
+		return nil, 0, pkgErr(MISUSE, "impl must implement Aggregator or be a func() Aggregator")
+	}
+	if window {
+		if _, ok := sample.(WindowAggregator); !ok {
+			return nil, 0, pkgErr(MISUSE, "impl must implement WindowAggregator to be used as a window function")
+		}
+	}
+
+	// Step/Inverse always take ...interface{}, so SQLite is told the
+	// function accepts any number of arguments; aggArgs converts each one
+	// generically rather than through fixed, per-position converters.
+	nArg := -1
+
+	done, ok := reflect.TypeOf(sample).MethodByName("Done")
+	if !ok {
+		return nil, 0, pkgErr(MISUSE, "impl has no Done method")
+	}
+	ret, err := callbackRet(done.Type.Out(0))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &aggFunc{newAgg: newAgg, ret: ret, window: window}, nArg, nil
+}
+
+// CreateAggregate registers a user-defined aggregate function. impl is
+// either a value implementing Aggregator, used unchanged across every
+// aggregation context, or a func() Aggregator constructor called once per
+// context so per-group state doesn't leak between groups. flags is a
+// combination of FuncDeterministic, FuncDirectOnly, FuncInnocuous, and
+// FuncSubtype; see their docs for what each one affects. SQLite does not
+// allow aggregate functions in index expressions, CHECK constraints, or
+// generated columns, whatever their flags; see Conn.RegisterFunc for a
+// deterministic scalar function backing an indexed column.
+func (c *Conn) CreateAggregate(name string, impl interface{}, flags FuncFlags) error {
+	f, nArg, err := newAggFunc(impl, false)
+	if err != nil {
+		return err
+	}
+	id := registerAggFunc(f)
+
+	rv := C._sqlite3_create_aggregate(c.db, cStr(name), C.int(nArg), flags.eTextRep(), handleOf(id))
+	if rv != C.SQLITE_OK {
+		return c.reserr("Conn.CreateAggregate")
+	}
+	return nil
+}
+
+// CreateWindowFunction registers a user-defined aggregate that can also be
+// used as a window function inside an OVER (...) clause. impl must
+// implement WindowAggregator (or be a func() WindowAggregator constructor).
+// See CreateAggregate for the meaning of flags.
+func (c *Conn) CreateWindowFunction(name string, impl interface{}, flags FuncFlags) error {
+	f, nArg, err := newAggFunc(impl, true)
+	if err != nil {
+		return err
+	}
+	id := registerAggFunc(f)
+
+	rv := C._sqlite3_create_window_function(c.db, cStr(name), C.int(nArg), flags.eTextRep(), handleOf(id))
+	if rv != C.SQLITE_OK {
+		return c.reserr("Conn.CreateWindowFunction")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import "testing"
+
+// sumAgg implements Aggregator and the extra WindowAggregator methods, so
+// it can be registered either way in the tests below.
+type sumAgg struct {
+	total int64
+	n     int
+}
+
+func (a *sumAgg) Step(args ...interface{}) error {
+	a.total += args[0].(int64)
+	a.n++
+	return nil
+}
+
+func (a *sumAgg) Done() (interface{}, error) {
+	if a.n == 0 {
+		return nil, nil
+	}
+	return a.total, nil
+}
+
+func (a *sumAgg) Inverse(args ...interface{}) error {
+	a.total -= args[0].(int64)
+	a.n--
+	return nil
+}
+
+func (a *sumAgg) Value() (interface{}, error) {
+	return a.Done()
+}
+
+func queryInt64Rows(t *testing.T, conn *Conn, query string) []int64 {
+	t.Helper()
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		t.Fatalf("Prepare(%q): %v", query, err)
+	}
+	defer stmt.Close()
+
+	var got []int64
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		var v int64
+		if err := stmt.Scan(&v); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestCreateAggregate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		setup []string
+		want  []int64
+	}{
+		{
+			name:  "empty input returns NULL",
+			query: "SELECT go_sum(x) FROM t",
+			setup: []string{"CREATE TABLE t (x INTEGER)"},
+			// sumAgg.Done returns nil (SQL NULL), which scans into an
+			// int64 as 0.
+			want: []int64{0},
+		},
+		{
+			name:  "grouped input sums per group",
+			query: "SELECT go_sum(x) FROM t GROUP BY g ORDER BY g",
+			setup: []string{
+				"CREATE TABLE t (g INTEGER, x INTEGER)",
+				"INSERT INTO t VALUES (1, 10), (1, 20), (2, 5)",
+			},
+			want: []int64{30, 5},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := Open(":memory:")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.CreateAggregate("go_sum", func() Aggregator { return &sumAgg{} }, FuncDeterministic); err != nil {
+				t.Fatalf("CreateAggregate: %v", err)
+			}
+			for _, stmt := range tc.setup {
+				if err := conn.Exec(stmt); err != nil {
+					t.Fatalf("Exec(%q): %v", stmt, err)
+				}
+			}
+
+			got := queryInt64Rows(t, conn, tc.query)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("row %d: got %d, want %d", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateWindowFunction(t *testing.T) {
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.CreateWindowFunction("go_sum", func() WindowAggregator { return &sumAgg{} }, FuncDeterministic); err != nil {
+		t.Fatalf("CreateWindowFunction: %v", err)
+	}
+
+	if err := conn.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if err := conn.Exec("INSERT INTO t VALUES (1), (2), (3), (4)"); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+
+	// A moving sum over a 3-row frame exercises both Inverse (as the frame
+	// slides past row 1) and Value (called once per row instead of Done).
+	query := "SELECT go_sum(x) OVER (ORDER BY x ROWS BETWEEN 1 PRECEDING AND 1 FOLLOWING) FROM t ORDER BY x"
+	want := []int64{3, 6, 9, 7}
+
+	got := queryInt64Rows(t, conn, query)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("row %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
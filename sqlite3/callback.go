@@ -5,6 +5,7 @@
 package sqlite3
 
 /*
+#include <stdlib.h>
 #include "sqlite3.h"
 
 // cgo doesn't handle SQLITE_{STATIC,TRANSIENT} pointer constants.
@@ -113,6 +114,12 @@ func callbackArgGeneric(v *C.sqlite3_value) (reflect.Value, error) {
 }
 
 func callbackArg(typ reflect.Type) (callbackArgConverter, error) {
+	if typ == valueType {
+		return callbackArgRawBytes, nil
+	}
+	if typ == timeType {
+		return callbackArgTime, nil
+	}
 	switch typ.Kind() {
 	case reflect.Interface:
 		if typ.NumMethod() != 0 {
@@ -198,8 +205,38 @@ func callbackRetText(ctx *C.sqlite3_context, v reflect.Value) error {
 	return nil
 }
 
+// callbackRetGeneric converts v, whose static type is interface{}, by
+// dispatching on its dynamic type at call time - used for functions such as
+// Aggregator.Done, whose result type isn't known until the value exists. v
+// may be the interface{} value itself (a scalar function's return) or the
+// value already taken out of it; a nil interface is reported as SQL NULL.
+func callbackRetGeneric(ctx *C.sqlite3_context, v reflect.Value) error {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		C.sqlite3_result_null(ctx)
+		return nil
+	}
+	conv, err := callbackRet(v.Type())
+	if err != nil {
+		return err
+	}
+	return conv(ctx, v)
+}
+
 func callbackRet(typ reflect.Type) (callbackRetConverter, error) {
+	if typ == timeType {
+		// Callers that want a different on-disk representation should use
+		// callbackRetTime directly with the desired TimeFormat.
+		return callbackRetTime(TimeFormatISO8601), nil
+	}
 	switch typ.Kind() {
+	case reflect.Interface:
+		if typ.NumMethod() != 0 {
+			return nil, pkgErr(MISUSE, "the only supported interface type is interface{}")
+		}
+		return callbackRetGeneric, nil
 	case reflect.Slice:
 		if typ.Elem().Kind() != reflect.Uint8 {
 			return nil, pkgErr(MISUSE, "the only supported slice type is []byte")
@@ -219,3 +256,155 @@ func callbackRet(typ reflect.Type) (callbackRetConverter, error) {
 func callbackError(ctx *C.sqlite3_context, err error) {
 	C.sqlite3_result_error(ctx, cStr(err.Error()), -1)
 }
+
+// handleOf wraps id in a small C-owned allocation suitable for passing as
+// the pApp argument of sqlite3_create_function_v2/sqlite3_create_collation_v2:
+// SQLite retains that pointer for as long as the registration lives, and
+// cgo forbids handing it a Go pointer that outlives the call. handleID
+// recovers id from the pointer the C callbacks receive back, and handleFree
+// releases the allocation once SQLite reports the registration destroyed.
+func handleOf(id uintptr) unsafe.Pointer {
+	p := C.malloc(C.size_t(unsafe.Sizeof(id)))
+	*(*uintptr)(p) = id
+	return p
+}
+
+func handleID(p unsafe.Pointer) uintptr {
+	return *(*uintptr)(p)
+}
+
+func handleFree(p unsafe.Pointer) {
+	C.free(p)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callbackArgs builds the per-parameter converters for fn, a Go function
+// being registered as a SQL scalar function. If fn's first parameter is
+// *Context, hasCtx reports true and it is excluded from args/nArg - the
+// caller receives the live sqlite3_context instead of a converted SQL
+// argument, letting it return large results without an extra copy (see
+// Context.ResultBlobNoCopy). If fn's last parameter is variadic, the
+// returned nArg is -1 (SQLite's "any number of arguments" marker) and
+// variadic reports true so the caller knows to pack the trailing SQL
+// arguments into a slice before calling fn.
+func callbackArgs(fn reflect.Type) (args []callbackArgConverter, variadic, hasCtx bool, nArg int, err error) {
+	first := 0
+	if fn.NumIn() > 0 && fn.In(0) == contextPtrType {
+		hasCtx = true
+		first = 1
+	}
+
+	n := fn.NumIn()
+	variadic = fn.IsVariadic()
+	args = make([]callbackArgConverter, n-first)
+	for i := first; i < n; i++ {
+		typ := fn.In(i)
+		if variadic && i == n-1 {
+			// fn.In(n-1) is the slice type (e.g. []int64); the converter
+			// operates on one element at a time.
+			typ = typ.Elem()
+		}
+		c, err := callbackArg(typ)
+		if err != nil {
+			return nil, false, false, 0, err
+		}
+		args[i-first] = c
+	}
+	if variadic {
+		nArg = -1
+	} else {
+		nArg = n - first
+	}
+	return args, variadic, hasCtx, nArg, nil
+}
+
+// callbackCallArgs splits argv into the reflect.Values to pass to a
+// (possibly variadic) function built by callbackArgs. The caller is
+// responsible for prepending a *Context value first when hasCtx was true.
+func callbackCallArgs(fn reflect.Type, args []callbackArgConverter, variadic bool, argv []*C.sqlite3_value) ([]reflect.Value, error) {
+	if variadic {
+		fixed := len(args) - 1
+		if len(argv) < fixed {
+			return nil, pkgErr(MISUSE, "function requires at least %d argument(s), got %d", fixed, len(argv))
+		}
+		in := make([]reflect.Value, 0, len(argv))
+		for i := 0; i < fixed; i++ {
+			v, err := args[i](argv[i])
+			if err != nil {
+				return nil, err
+			}
+			in = append(in, v)
+		}
+		elemConv := args[fixed]
+		for i := fixed; i < len(argv); i++ {
+			v, err := elemConv(argv[i])
+			if err != nil {
+				return nil, err
+			}
+			in = append(in, v)
+		}
+		return in, nil
+	}
+
+	in := make([]reflect.Value, len(argv))
+	for i, v := range argv {
+		val, err := args[i](v)
+		if err != nil {
+			return nil, err
+		}
+		in[i] = val
+	}
+	return in, nil
+}
+
+// callbackReturn inspects a registered function's return signature, which
+// must be either a single value convertible via callbackRet, or a (T,
+// error) pair. hasErr reports which form it is so the caller knows to
+// check out[1] after calling fn. timeFormat selects the on-disk
+// representation when the return type is time.Time; it is ignored
+// otherwise. A function with hasCtx set may instead return nothing at all,
+// having already set its result directly through the *Context it received
+// (see Context.ResultBlobNoCopy); ret is nil in that case and the caller
+// must not invoke it.
+func callbackReturn(fn reflect.Type, timeFormat TimeFormat, hasCtx bool) (ret callbackRetConverter, hasErr bool, err error) {
+	retConv := func(typ reflect.Type) (callbackRetConverter, error) {
+		if typ == timeType {
+			return callbackRetTime(timeFormat), nil
+		}
+		return callbackRet(typ)
+	}
+
+	switch fn.NumOut() {
+	case 0:
+		if !hasCtx {
+			return nil, false, pkgErr(MISUSE, "function must return 1 value, or (value, error)")
+		}
+		return nil, false, nil
+	case 1:
+		ret, err = retConv(fn.Out(0))
+		return ret, false, err
+	case 2:
+		if fn.Out(1) != errorType {
+			return nil, false, pkgErr(MISUSE, "second return value must be error")
+		}
+		ret, err = retConv(fn.Out(0))
+		return ret, true, err
+	default:
+		return nil, false, pkgErr(MISUSE, "function must return 1 value, or (value, error)")
+	}
+}
+
+// callbackReturnValue extracts the result value and optional error from
+// calling a function built with callbackReturn. out is empty for a function
+// that returned nothing (see callbackReturn); the caller should then skip
+// converting a result.
+func callbackReturnValue(out []reflect.Value, hasErr bool) (reflect.Value, error) {
+	if len(out) == 0 {
+		return reflect.Value{}, nil
+	}
+	if hasErr && !out[1].IsNil() {
+		return reflect.Value{}, out[1].Interface().(error)
+	}
+	return out[0], nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+extern int _go_collation_cmp(void*, int, void*, int, void*);
+extern void _go_collation_destroy(void*);
+
+static int _sqlite3_create_collation(sqlite3* db, const char* name, void* handle) {
+  return sqlite3_create_collation_v2(db, name, SQLITE_UTF8, handle,
+    (int(*)(void*, int, const void*, int, const void*))_go_collation_cmp, _go_collation_destroy);
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// collationRegistry maps the opaque handle passed to sqlite3_user_data
+// (here, the void* pApp argument of sqlite3_create_collation_v2) back to
+// the Go comparator, so the C callback never has to carry a Go pointer.
+var collationRegistry = struct {
+	sync.Mutex
+	m    map[uintptr]func(a, b string) int
+	next uintptr
+}{m: map[uintptr]func(a, b string) int{}}
+
+func registerCollation(cmp func(a, b string) int) uintptr {
+	collationRegistry.Lock()
+	defer collationRegistry.Unlock()
+	collationRegistry.next++
+	id := collationRegistry.next
+	collationRegistry.m[id] = cmp
+	return id
+}
+
+//export _go_collation_cmp
+func _go_collation_cmp(handle unsafe.Pointer, lenA C.int, a unsafe.Pointer, lenB C.int, b unsafe.Pointer) C.int {
+	id := handleID(handle)
+	collationRegistry.Lock()
+	cmp := collationRegistry.m[id]
+	collationRegistry.Unlock()
+	if cmp == nil {
+		return 0
+	}
+	sa := C.GoStringN((*C.char)(a), lenA)
+	sb := C.GoStringN((*C.char)(b), lenB)
+	return C.int(cmp(sa, sb))
+}
+
+//export _go_collation_destroy
+func _go_collation_destroy(handle unsafe.Pointer) {
+	id := handleID(handle)
+	collationRegistry.Lock()
+	delete(collationRegistry.m, id)
+	collationRegistry.Unlock()
+	handleFree(handle)
+}
+
+// CreateCollation registers a custom text collating sequence, usable in
+// ORDER BY, COLLATE clauses, and indexes defined with COLLATE name. cmp
+// must implement a total order: negative if a < b, zero if equal, positive
+// if a > b, matching the contract of sqlite3_create_collation's callback.
+//
+// This makes it possible to back a collation with, for example,
+// golang.org/x/text/collate for locale-aware or natural sort order, or a
+// simple strings.EqualFold-based case-folding comparison.
+func (c *Conn) CreateCollation(name string, cmp func(a, b string) int) error {
+	id := registerCollation(cmp)
+	rv := C._sqlite3_create_collation(c.db, cStr(name), handleOf(id))
+	if rv != C.SQLITE_OK {
+		return c.reserr("Conn.CreateCollation")
+	}
+	return nil
+}
+
+// CreateCollationBytes is like CreateCollation but passes cmp the compared
+// values as byte slices, for byte-oriented comparators. It wraps
+// CreateCollation, so each value is first copied into a string and then
+// into a []byte; it does not avoid the string conversion.
+func (c *Conn) CreateCollationBytes(name string, cmp func(a, b []byte) int) error {
+	return c.CreateCollation(name, func(a, b string) int {
+		return cmp([]byte(a), []byte(b))
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"strings"
+	"testing"
+)
+
+func queryTextRows(t *testing.T, conn *Conn, query string) []string {
+	t.Helper()
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		t.Fatalf("Prepare(%q): %v", query, err)
+	}
+	defer stmt.Close()
+
+	var got []string
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		var v string
+		if err := stmt.Scan(&v); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestCreateCollation(t *testing.T) {
+	tests := []struct {
+		name string
+		cmp  func(a, b string) int
+		want []string
+	}{
+		{
+			name: "case-insensitive",
+			cmp: func(a, b string) int {
+				return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+			},
+			want: []string{"apple", "Banana", "cherry"},
+		},
+		{
+			name: "reverse",
+			cmp: func(a, b string) int {
+				return strings.Compare(b, a)
+			},
+			want: []string{"cherry", "apple", "Banana"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := Open(":memory:")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.CreateCollation("go_cmp", tc.cmp); err != nil {
+				t.Fatalf("CreateCollation: %v", err)
+			}
+			if err := conn.Exec("CREATE TABLE t (x TEXT)"); err != nil {
+				t.Fatalf("Exec: %v", err)
+			}
+			if err := conn.Exec("INSERT INTO t VALUES ('Banana'), ('apple'), ('cherry')"); err != nil {
+				t.Fatalf("Exec: %v", err)
+			}
+
+			got := queryTextRows(t, conn, "SELECT x FROM t ORDER BY x COLLATE go_cmp")
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("row %d: got %q, want %q", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
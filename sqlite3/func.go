@@ -0,0 +1,162 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+extern void _go_func_call(sqlite3_context*, int, sqlite3_value**);
+extern void _go_func_destroy(void*);
+
+static int _sqlite3_create_func(sqlite3* db, const char* name, int nArg, int eTextRep, void* handle) {
+  return sqlite3_create_function_v2(db, name, nArg, eTextRep, handle, _go_func_call, 0, 0, _go_func_destroy);
+}
+*/
+import "C"
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// sqlFunc describes a registered scalar function: how to convert SQL
+// arguments into Go values, how to call the Go function, and how to convert
+// its result back.
+type sqlFunc struct {
+	fn       reflect.Value
+	args     []callbackArgConverter
+	variadic bool
+	hasCtx   bool
+	ret      callbackRetConverter
+	hasErr   bool
+}
+
+// funcRegistry maps the opaque handle passed as sqlite3_user_data to the
+// sqlFunc registered for it, mirroring aggRegistry's indirection so no Go
+// pointer has to cross into C. Every access, including from the C
+// trampolines, goes through the lock.
+var funcRegistry = struct {
+	sync.Mutex
+	m    map[uintptr]*sqlFunc
+	next uintptr
+}{m: map[uintptr]*sqlFunc{}}
+
+func registerSQLFunc(f *sqlFunc) uintptr {
+	funcRegistry.Lock()
+	defer funcRegistry.Unlock()
+	funcRegistry.next++
+	id := funcRegistry.next
+	funcRegistry.m[id] = f
+	return id
+}
+
+func lookupSQLFunc(id uintptr) *sqlFunc {
+	funcRegistry.Lock()
+	defer funcRegistry.Unlock()
+	return funcRegistry.m[id]
+}
+
+//export _go_func_call
+func _go_func_call(ctx *C.sqlite3_context, n C.int, argv **C.sqlite3_value) {
+	id := handleID(C.sqlite3_user_data(ctx))
+	f := lookupSQLFunc(id)
+
+	values := unsafe.Slice(argv, int(n))
+	in, err := callbackCallArgs(f.fn.Type(), f.args, f.variadic, values)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	if f.hasCtx {
+		in = append([]reflect.Value{reflect.ValueOf(&Context{ctx: ctx})}, in...)
+	}
+
+	out := f.fn.Call(in)
+	result, err := callbackReturnValue(out, f.hasErr)
+	if err != nil {
+		callbackError(ctx, err)
+		return
+	}
+	if f.ret == nil {
+		// The function set its result directly through *Context instead of
+		// returning a value; see callbackReturn.
+		return
+	}
+	if err := f.ret(ctx, result); err != nil {
+		callbackError(ctx, err)
+	}
+}
+
+//export _go_func_destroy
+func _go_func_destroy(handle unsafe.Pointer) {
+	id := handleID(handle)
+	funcRegistry.Lock()
+	delete(funcRegistry.m, id)
+	funcRegistry.Unlock()
+	handleFree(handle)
+}
+
+// FuncOption configures optional, less commonly needed behavior for a
+// function registered with Conn.RegisterFunc.
+type FuncOption func(*funcOptions)
+
+type funcOptions struct {
+	timeFormat TimeFormat
+}
+
+// WithTimeFormat selects how a registered function's time.Time return value
+// is serialized back to SQLite. Without this option, RegisterFunc uses
+// TimeFormatISO8601.
+func WithTimeFormat(f TimeFormat) FuncOption {
+	return func(o *funcOptions) { o.timeFormat = f }
+}
+
+// RegisterFunc registers fn as a SQL scalar function named name. fn may
+// take a trailing variadic parameter, in which case SQLite is told the
+// function accepts any number of arguments, and may return either a single
+// value or (value, error); a non-nil error is reported to SQLite as the
+// function's result instead. If fn's first parameter is *Context, it
+// receives the live sqlite3_context instead of a converted SQL argument; fn
+// may then either return a value as usual or return nothing, having already
+// set the result itself (e.g. via Context.ResultBlobNoCopy). flags is a
+// combination of FuncDeterministic, FuncDirectOnly, FuncInnocuous, and
+// FuncSubtype - see their docs for what each affects. For example, a
+// deterministic function can back an indexed generated column:
+//
+//	db.RegisterFunc("my_lower", strings.ToLower, sqlite3.FuncDeterministic)
+//	db.Exec("CREATE TABLE t (x TEXT, lx TEXT AS (my_lower(x)))")
+//	db.Exec("CREATE INDEX t_lx ON t (lx)")
+func (c *Conn) RegisterFunc(name string, fn interface{}, flags FuncFlags, opts ...FuncOption) error {
+	var o funcOptions
+	o.timeFormat = TimeFormatISO8601
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return pkgErr(MISUSE, "fn must be a function, got %T", fn)
+	}
+	typ := v.Type()
+
+	args, variadic, hasCtx, nArg, err := callbackArgs(typ)
+	if err != nil {
+		return err
+	}
+	ret, hasErr, err := callbackReturn(typ, o.timeFormat, hasCtx)
+	if err != nil {
+		return err
+	}
+
+	f := &sqlFunc{fn: v, args: args, variadic: variadic, hasCtx: hasCtx, ret: ret, hasErr: hasErr}
+	id := registerSQLFunc(f)
+
+	rv := C._sqlite3_create_func(c.db, cStr(name), C.int(nArg), flags.eTextRep(), handleOf(id))
+	if rv != C.SQLITE_OK {
+		return c.reserr("Conn.RegisterFunc")
+	}
+	return nil
+}
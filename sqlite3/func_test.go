@@ -0,0 +1,188 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func queryRow(t *testing.T, conn *Conn, query string, dest ...interface{}) {
+	t.Helper()
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		t.Fatalf("Prepare(%q): %v", query, err)
+	}
+	defer stmt.Close()
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		t.Fatalf("Step(%q): %v", query, err)
+	}
+	if !hasRow {
+		t.Fatalf("query %q returned no rows", query)
+	}
+	if err := stmt.Scan(dest...); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+}
+
+func TestRegisterFuncVariadic(t *testing.T) {
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer conn.Close()
+
+	sum := func(first int64, rest ...int64) int64 {
+		total := first
+		for _, r := range rest {
+			total += r
+		}
+		return total
+	}
+	if err := conn.RegisterFunc("go_sum", sum, FuncDeterministic); err != nil {
+		t.Fatalf("RegisterFunc: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  int64
+	}{
+		{"SELECT go_sum(5)", 5},       // no trailing args
+		{"SELECT go_sum(1, 2, 3)", 6}, // N trailing args
+	}
+	for _, tc := range tests {
+		var got int64
+		queryRow(t, conn, tc.query, &got)
+		if got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterFuncErrorReturn(t *testing.T) {
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer conn.Close()
+
+	div := func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}
+	if err := conn.RegisterFunc("go_div", div, FuncDeterministic); err != nil {
+		t.Fatalf("RegisterFunc: %v", err)
+	}
+
+	var got int64
+	queryRow(t, conn, "SELECT go_div(10, 2)", &got)
+	if got != 5 {
+		t.Errorf("go_div(10, 2): got %d, want 5", got)
+	}
+
+	stmt, err := conn.Prepare("SELECT go_div(10, 0)")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Step(); err == nil {
+		t.Error("go_div(10, 0): expected an error, got none")
+	}
+}
+
+func TestRegisterFuncTimeFormat(t *testing.T) {
+	fixed := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format TimeFormat
+	}{
+		{"ISO8601", TimeFormatISO8601},
+		{"Unix", TimeFormatUnix},
+		{"Julian", TimeFormatJulian},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := Open(":memory:")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer conn.Close()
+
+			emit := func() time.Time { return fixed }
+			if err := conn.RegisterFunc("go_emit", emit, FuncDeterministic, WithTimeFormat(tc.format)); err != nil {
+				t.Fatalf("RegisterFunc(go_emit): %v", err)
+			}
+			unix := func(t time.Time) int64 { return t.Unix() }
+			if err := conn.RegisterFunc("go_unix", unix, FuncDeterministic); err != nil {
+				t.Fatalf("RegisterFunc(go_unix): %v", err)
+			}
+
+			var got int64
+			queryRow(t, conn, "SELECT go_unix(go_emit())", &got)
+			// TimeFormatJulian round-trips through a float64 day count, so a
+			// one-second rounding slip is expected; the other formats are exact.
+			tolerance := int64(0)
+			if tc.format == TimeFormatJulian {
+				tolerance = 1
+			}
+			if diff := got - fixed.Unix(); diff < -tolerance || diff > tolerance {
+				t.Errorf("got %d, want %d (±%d)", got, fixed.Unix(), tolerance)
+			}
+		})
+	}
+}
+
+func TestRegisterFuncTimeArgDriverFormat(t *testing.T) {
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer conn.Close()
+
+	echo := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	if err := conn.RegisterFunc("go_echo", echo, FuncDeterministic); err != nil {
+		t.Fatalf("RegisterFunc: %v", err)
+	}
+
+	var got string
+	queryRow(t, conn, `SELECT go_echo('2020-01-02 15:04:05')`, &got)
+	want := "2020-01-02T15:04:05Z"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRegisterFuncContextResultBlobNoCopy(t *testing.T) {
+	conn, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer conn.Close()
+
+	want := "hello from a C-owned buffer"
+	fn := func(c *Context) {
+		p := CBytes([]byte(want))
+		// Intentionally not freed: ResultBlobNoCopy's SQLITE_STATIC contract
+		// keeps SQLite pointing at p until the statement that consumes it is
+		// done with the column, which outlives this call.
+		c.ResultBlobNoCopy(p, len(want))
+	}
+	if err := conn.RegisterFunc("go_blob", fn, FuncDeterministic); err != nil {
+		t.Fatalf("RegisterFunc: %v", err)
+	}
+
+	var got string
+	queryRow(t, conn, "SELECT go_blob()", &got)
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
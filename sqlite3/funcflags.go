@@ -0,0 +1,46 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+*/
+import "C"
+
+// FuncFlags is a bitmask of extra properties to register alongside a user
+// function, aggregate, or window function. The flags are OR'd into the
+// eTextRep argument passed to sqlite3_create_function_v2, matching the
+// values SQLite itself expects there.
+type FuncFlags int
+
+const (
+	// FuncDeterministic marks the function as SQLITE_DETERMINISTIC: it
+	// always returns the same result for the same arguments within a
+	// single query. This is required for the function to be usable in an
+	// index expression or a CHECK constraint.
+	FuncDeterministic FuncFlags = C.SQLITE_DETERMINISTIC
+
+	// FuncDirectOnly marks the function as SQLITE_DIRECTONLY, preventing
+	// it from being invoked from triggers, views, or schema expressions
+	// where the caller may not control the SQL text. Recommended for any
+	// function with side effects or that reads external state.
+	FuncDirectOnly FuncFlags = C.SQLITE_DIRECTONLY
+
+	// FuncInnocuous marks the function as SQLITE_INNOCUOUS: it has no
+	// side effects and does not disclose information beyond its
+	// arguments, so it is safe to use from triggers, views, and schema
+	// expressions even when the schema is untrusted.
+	FuncInnocuous FuncFlags = C.SQLITE_INNOCUOUS
+
+	// FuncSubtype marks the function as SQLITE_SUBTYPE, indicating it may
+	// call sqlite3_value_subtype() on its arguments.
+	FuncSubtype FuncFlags = C.SQLITE_SUBTYPE
+)
+
+// eTextRep ORs the flags into SQLITE_UTF8, the text encoding every
+// registration entry point uses.
+func (f FuncFlags) eTextRep() C.int {
+	return C.int(SQLITE_UTF8) | C.int(f)
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+// Declared (non-static) in callback.go; re-declared here so cgo can see it
+// from this translation unit too.
+extern void _sqlite3_result_text(sqlite3_context* ctx, const char* s);
+*/
+import "C"
+
+import (
+	"reflect"
+	"time"
+)
+
+// TimeFormat selects how a registered function serializes a time.Time
+// return value back to SQLite. It mirrors the formats the driver already
+// understands when binding and scanning time.Time at the statement level.
+type TimeFormat int
+
+const (
+	// TimeFormatISO8601 stores the time as TEXT, formatted with
+	// time.RFC3339Nano in UTC. This is the default and is the format
+	// callbackArgTime accepts unambiguously alongside the other two.
+	TimeFormatISO8601 TimeFormat = iota
+	// TimeFormatUnix stores the time as an INTEGER number of seconds
+	// since the Unix epoch.
+	TimeFormatUnix
+	// TimeFormatJulian stores the time as a FLOAT Julian day number, the
+	// representation SQLite's own date/time functions use internally.
+	TimeFormatJulian
+)
+
+const julianUnixEpoch = 2440587.5 // Julian day number of 1970-01-01T00:00:00Z
+
+// timeParseFormats are tried in order when parsing a TEXT argument as a
+// time.Time. The first is callbackRetTime's own TimeFormatISO8601 output;
+// the rest are the space-separated "YYYY-MM-DD HH:MM:SS[.fff]" forms the
+// driver's Bind/Scan layer uses for time.Time, so a value written by either
+// side round-trips through a registered function's time.Time argument.
+var timeParseFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func callbackArgTime(v *C.sqlite3_value) (reflect.Value, error) {
+	var t time.Time
+	switch C.sqlite3_value_type(v) {
+	case INTEGER:
+		secs := int64(C.sqlite3_value_int64(v))
+		t = time.Unix(secs, 0).UTC()
+	case FLOAT:
+		jd := float64(C.sqlite3_value_double(v))
+		secs := (jd - julianUnixEpoch) * 86400
+		t = time.Unix(int64(secs), int64((secs-float64(int64(secs)))*1e9)).UTC()
+	case TEXT:
+		s, err := callbackArgString(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		str := s.Interface().(string)
+		parsed, err := parseTime(str)
+		if err != nil {
+			return reflect.Value{}, pkgErr(MISUSE, "cannot parse %q as a time: %s", str, err)
+		}
+		t = parsed.UTC()
+	default:
+		return reflect.Value{}, pkgErr(MISUSE, "argument must be an INTEGER, FLOAT, or TEXT that can be interpreted as a time")
+	}
+	return reflect.ValueOf(t), nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, format := range timeParseFormats {
+		var t time.Time
+		t, err = time.Parse(format, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func callbackRetTime(format TimeFormat) callbackRetConverter {
+	return func(ctx *C.sqlite3_context, v reflect.Value) error {
+		t, ok := v.Interface().(time.Time)
+		if !ok {
+			return pkgErr(MISUSE, "cannot convert %s to time.Time", v.Type())
+		}
+		switch format {
+		case TimeFormatUnix:
+			C.sqlite3_result_int64(ctx, C.sqlite3_int64(t.Unix()))
+		case TimeFormatJulian:
+			jd := julianUnixEpoch + float64(t.UnixNano())/1e9/86400
+			C.sqlite3_result_double(ctx, C.double(jd))
+		case TimeFormatISO8601:
+			C._sqlite3_result_text(ctx, cStr(t.UTC().Format(time.RFC3339Nano)))
+		default:
+			return pkgErr(MISUSE, "unknown TimeFormat %d", format)
+		}
+		return nil
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
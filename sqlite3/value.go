@@ -0,0 +1,111 @@
+// Copyright 2018 The go-sqlite-lite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite3
+
+/*
+#include "sqlite3.h"
+
+// cgo doesn't handle the SQLITE_STATIC pointer constant.
+static void _sqlite3_result_blob_nocopy(sqlite3_context* ctx, const void* b, int l) {
+  sqlite3_result_blob(ctx, b, l, SQLITE_STATIC);
+}
+*/
+import "C"
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Value is a borrowed view of a single SQL function argument. Unlike the
+// []byte/string parameter types, Blob and Text return slices backed
+// directly by SQLite's own memory instead of a Go copy, so they avoid an
+// allocation for large BLOB/TEXT arguments. The returned slices are only
+// valid for the duration of the call they were obtained in; callers that
+// need to keep the data must copy it themselves.
+type Value struct {
+	v *C.sqlite3_value
+}
+
+// Type reports the storage class of the underlying SQL value (INTEGER,
+// FLOAT, TEXT, BLOB, or NULL).
+func (v Value) Type() int {
+	return int(C.sqlite3_value_type(v.v))
+}
+
+// Blob returns the argument's bytes without copying them. It is only valid
+// when Type is BLOB; for any other storage class it returns nil.
+func (v Value) Blob() []byte {
+	if C.sqlite3_value_type(v.v) != BLOB {
+		return nil
+	}
+	l := int(C.sqlite3_value_bytes(v.v))
+	if l == 0 {
+		return nil
+	}
+	p := C.sqlite3_value_blob(v.v)
+	return unsafe.Slice((*byte)(p), l)
+}
+
+// Text returns the argument's UTF-8 bytes without copying them. It is only
+// valid when Type is TEXT; for any other storage class it returns nil.
+func (v Value) Text() []byte {
+	if C.sqlite3_value_type(v.v) != TEXT {
+		return nil
+	}
+	l := int(C.sqlite3_value_bytes(v.v))
+	if l == 0 {
+		return nil
+	}
+	p := unsafe.Pointer(C.sqlite3_value_text(v.v))
+	return unsafe.Slice((*byte)(p), l)
+}
+
+// callbackArgRawBytes wraps every storage class as a Value rather than
+// rejecting INTEGER/FLOAT/NULL: Blob and Text already report nil for a
+// Value whose Type isn't BLOB/TEXT, so a function taking sqlite3.Value can
+// branch on Type the same way the copying []byte/string path handles NULL
+// by receiving a nil slice, instead of being uninvokable on those arguments.
+func callbackArgRawBytes(v *C.sqlite3_value) (reflect.Value, error) {
+	return reflect.ValueOf(Value{v: v}), nil
+}
+
+var valueType = reflect.TypeOf(Value{})
+
+// Context gives a user function direct access to the sqlite3_context it is
+// running under, so it can return large results, such as a buffer it
+// already owns, without an extra copy. A function registered with a first
+// parameter of type *Context receives it instead of a converted SQL
+// argument; the context is only valid for the duration of the call.
+type Context struct {
+	ctx *C.sqlite3_context
+}
+
+// ResultBlobNoCopy sets the function's result to the n bytes at p without
+// copying them; if n is 0 the result is NULL instead of an empty BLOB, and
+// p is not used. p is passed to SQLite with SQLITE_STATIC, so it must point
+// to C-owned memory (e.g. allocated with C.malloc, as with the handleOf
+// helper) that the caller guarantees stays valid and unmodified for as long
+// as SQLite may hold onto the result - typically the lifetime of the
+// current statement step. A Go slice is never a legal p: cgo forbids C
+// retaining a Go pointer past the call that handed it over, which is
+// exactly what SQLITE_STATIC does. Callers with a Go []byte they don't mind
+// copying should use the ordinary blob return path instead.
+func (c *Context) ResultBlobNoCopy(p unsafe.Pointer, n int) {
+	if n == 0 {
+		C.sqlite3_result_null(c.ctx)
+		return
+	}
+	C._sqlite3_result_blob_nocopy(c.ctx, p, C.int(n))
+}
+
+var contextPtrType = reflect.TypeOf((*Context)(nil))
+
+// CBytes copies b into newly allocated C memory and returns a pointer to it,
+// suitable as the p argument to ResultBlobNoCopy. The caller is responsible
+// for freeing the returned pointer once SQLite is done with the result.
+func CBytes(b []byte) unsafe.Pointer {
+	return C.CBytes(b)
+}